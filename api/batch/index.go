@@ -0,0 +1,171 @@
+// Package handler implements the /batch endpoint: the same extraction as
+// the single-URL handler, fanned out over many URLs at once with bounded
+// concurrency.
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	rootapi "github.com/takameron/meta-api/api"
+	"github.com/takameron/meta-api/cache"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultMaxURLs     = 50
+	defaultWorkerCount = 8
+)
+
+// BatchItem is one URL's outcome within a batch response. success/error/
+// elapsed_ms live alongside the usual extraction payload so one bad URL
+// doesn't poison the rest of the batch.
+type BatchItem struct {
+	URL       string            `json:"url"`
+	Success   bool              `json:"success"`
+	Error     string            `json:"error,omitempty"`
+	ElapsedMs int64             `json:"elapsed_ms"`
+	Result    *rootapi.Response `json:"result,omitempty"`
+}
+
+type batchBody struct {
+	URLs []string `json:"urls"`
+}
+
+// parseBatchURLs reads the target URLs from a POST JSON body ({"urls": [...]})
+// or, for GET, from a comma-separated ?urls=a,b,c query parameter.
+func parseBatchURLs(r *http.Request) ([]string, error) {
+	if r.Method == http.MethodPost {
+		var body batchBody
+		dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+		if err := dec.Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		return body.URLs, nil
+	}
+
+	raw := r.URL.Query().Get("urls")
+	if raw == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	urls := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			urls = append(urls, p)
+		}
+	}
+	return urls, nil
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	urls, err := parseBatchURLs(r)
+	if err != nil {
+		rootapi.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(urls) == 0 {
+		rootapi.WriteError(w, http.StatusBadRequest, "need at least one url")
+		return
+	}
+	if len(urls) > defaultMaxURLs {
+		rootapi.WriteError(w, http.StatusBadRequest, fmt.Sprintf("too many urls, max %d", defaultMaxURLs))
+		return
+	}
+
+	queries := r.URL.Query()
+	skipOEmbed := queries.Get("oembed") == "false"
+
+	workers := defaultWorkerCount
+	if n, err := strconv.Atoi(queries.Get("workers")); err == nil && n > 0 {
+		workers = n
+	}
+
+	// A batch-scoped singleflight group collapses duplicate URLs within
+	// this one request into a single upstream fetch.
+	var dedup singleflight.Group
+	var eg errgroup.Group
+	eg.SetLimit(workers)
+
+	results := make([]BatchItem, len(urls))
+	for i, u := range urls {
+		i, u := i, u
+		eg.Go(func() error {
+			results[i] = fetchBatchItem(&dedup, u, skipOEmbed, queries)
+			return nil
+		})
+	}
+	eg.Wait()
+
+	msg, _ := json.Marshal(results)
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Add("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Max-Age", "86400")
+	w.Header().Add("Content-Type", "application/json;charset=UTF-8")
+	w.Write(msg)
+}
+
+// fetchBatchItem fetches a single URL, sharing the cache and the retrying
+// http.Client with the rest of the API, and times the whole operation for
+// elapsed_ms.
+func fetchBatchItem(dedup *singleflight.Group, rawURL string, skipOEmbed bool, queries url.Values) BatchItem {
+	start := time.Now()
+	item := BatchItem{URL: rawURL}
+
+	noCache := queries.Get("nocache") == "1"
+
+	v, err, _ := dedup.Do(rawURL, func() (interface{}, error) {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		auth := rootapi.AuthHandlerFor(queries, req.URL.Hostname())
+		render := rootapi.RendererFor(queries)
+		key := rootapi.CacheKeyFor(req, auth)
+
+		if !noCache {
+			if data, status, ok := rootapi.CacheLookup(key); ok {
+				switch status {
+				case "HIT":
+					cache.Default.RecordHit()
+					return data, nil
+				case "STALE":
+					cache.Default.RecordStale()
+					go rootapi.RefreshCache(req, key, skipOEmbed, auth, render)
+					return data, nil
+				}
+			}
+			cache.Default.RecordMiss()
+		}
+
+		data, ttl, err := rootapi.FetchAndBuild(req, skipOEmbed, auth, render)
+		if err != nil {
+			return nil, err
+		}
+		if !noCache {
+			rootapi.StoreInCache(key, data, ttl)
+		}
+		return data, nil
+	})
+
+	item.ElapsedMs = time.Since(start).Milliseconds()
+	if err != nil {
+		item.Success = false
+		item.Error = err.Error()
+		return item
+	}
+
+	item.Success = true
+	item.Result = v.(*rootapi.Response)
+	return item
+}
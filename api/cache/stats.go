@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/takameron/meta-api/cache"
+)
+
+// Handler reports cumulative hit/miss/stale counters for the shared page
+// cache used by the main fetch handler.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	stats := cache.Default.Stats()
+	msg, _ := json.Marshal(stats)
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Content-Type", "application/json;charset=UTF-8")
+	w.Write(msg)
+}
@@ -2,27 +2,70 @@ package handler
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-encoding"
+	"github.com/takameron/meta-api/cache"
+	"github.com/takameron/meta-api/fetcher"
+	"github.com/takameron/meta-api/renderer"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMaxBytes   = 5 * 1024 * 1024 // 5 MB
+	defaultMaxRetries = 2
+	maxRedirects      = 10
+	userAgent         = "meta-api/1.0 (+https://github.com/takameron/meta-api)"
+)
+
+var (
+	httpClient  = newHTTPClient()
+	maxBodyByte = envInt64("METAAPI_MAX_BYTES", defaultMaxBytes)
+
+	// refetchGroup collapses concurrent stale-while-revalidate refreshes
+	// of the same URL into a single upstream fetch.
+	refetchGroup singleflight.Group
+
+	// hostCredentials are server-side credentials for protected origins,
+	// keyed by hostname and loaded once at startup from
+	// METAAPI_AUTH_CONFIG. Preferred over passing credentials per request.
+	hostCredentials = fetcher.LoadHostCredentials()
+
+	// cdpRenderer is the JS-rendering backend used when a request passes
+	// ?render=js, configured once at startup from METAAPI_CDP_URL,
+	// METAAPI_CDP_ALLOWED_HOSTS and METAAPI_CDP_TIMEOUT.
+	cdpRenderer = renderer.ChromeDPRenderer{
+		CDPURL:       os.Getenv("METAAPI_CDP_URL"),
+		AllowedHosts: renderer.AllowedHostsFromEnv(),
+		Timeout:      envDuration("METAAPI_CDP_TIMEOUT", 8*time.Second),
+	}
 )
 
 type Response struct {
-	Host       string            `json:"host"`
-	Metas      map[string]string `json:"metas"`
-	Proto      string            `json:"protocol"`
-	StatusCode int               `json:"status_code"`
-	StatusText string            `json:"status_text"`
-	Success    bool              `json:"success"`
-	Title      string            `json:"title"`
-	URL        string            `json:"url"`
+	Host       string                   `json:"host"`
+	Metas      map[string]string        `json:"metas"`
+	JSONLD     []map[string]interface{} `json:"json_ld,omitempty"`
+	OEmbed     map[string]interface{}   `json:"oembed,omitempty"`
+	Warnings   []string                 `json:"warnings,omitempty"`
+	Proto      string                   `json:"protocol"`
+	StatusCode int                      `json:"status_code"`
+	StatusText string                   `json:"status_text"`
+	Success    bool                     `json:"success"`
+	Title      string                   `json:"title"`
+	URL        string                   `json:"url"`
 }
 
 type ResponseError struct {
@@ -30,6 +73,93 @@ type ResponseError struct {
 	Success bool   `json:"success"`
 }
 
+// envInt reads an integer from the named environment variable, falling back
+// to def when it is unset or not a valid integer.
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// envInt64 is envInt for int64-sized settings such as byte limits.
+func envInt64(name string, def int64) int64 {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// envDuration reads a duration in whole seconds from the named environment
+// variable, falling back to def when it is unset or not a valid integer.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return def
+}
+
+// retryTransport wraps a base RoundTripper with a User-Agent header and
+// exponential-backoff retries on 5xx responses and connection errors.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", userAgent)
+
+	var res *http.Response
+	var err error
+	for attempt := 0; attempt <= t.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff(attempt))
+		}
+
+		res, err = t.base.RoundTrip(req)
+		if err == nil && res.StatusCode < 500 {
+			return res, nil
+		}
+		if err == nil && attempt < t.maxRetries {
+			res.Body.Close()
+		}
+	}
+	return res, err
+}
+
+// retryBackoff returns an exponentially increasing delay with jitter so
+// concurrent retries against the same origin don't line up in lockstep.
+func retryBackoff(attempt int) time.Duration {
+	base := time.Duration(1<<uint(attempt-1)) * 100 * time.Millisecond
+	jitter := time.Duration(rand.Int63n(int64(base) / 2))
+	return base + jitter
+}
+
+// newHTTPClient builds the shared client used for every upstream fetch:
+// bounded timeout, a capped redirect chain, and retrying transport. Tunables
+// are read once at startup from METAAPI_TIMEOUT and METAAPI_MAX_RETRIES.
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: envDuration("METAAPI_TIMEOUT", defaultTimeout),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+		Transport: &retryTransport{
+			base:       http.DefaultTransport,
+			maxRetries: envInt("METAAPI_MAX_RETRIES", defaultMaxRetries),
+		},
+	}
+}
+
 func convertUTF8(reader io.Reader, contentType string) io.Reader {
 	br := bufio.NewReader(reader)
 	var r io.Reader = br
@@ -46,22 +176,59 @@ func convertUTF8(reader io.Reader, contentType string) io.Reader {
 	return r
 }
 
-func getTags(reader io.Reader) (string, map[string]string, error) {
+// WriteError writes a ResponseError as JSON with the given HTTP status code.
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	data := ResponseError{Success: false, Msg: msg}
+	body, _ := json.Marshal(data)
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// parseJSONLD decodes a single <script type="application/ld+json"> island.
+// Schema.org allows either one object or an array of objects in the same
+// script block, so both shapes are normalized to a slice.
+func parseJSONLD(raw string) ([]map[string]interface{}, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var arr []map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &arr); err == nil {
+		return arr, nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		return nil, err
+	}
+	return []map[string]interface{}{obj}, nil
+}
+
+// getTags walks the <head> for <title>, <meta>, JSON-LD and oEmbed discovery
+// tags, then keeps scanning the body only long enough to pick up the first
+// JSON-LD island that sites sometimes place there instead. JSON-LD parse
+// failures are collected as warnings rather than aborting the scan.
+func getTags(reader io.Reader) (string, map[string]string, []map[string]interface{}, string, []string) {
 	title := ""
 	metas := map[string]string{}
+	var jsonld []map[string]interface{}
+	oembedHref := ""
+	var warnings []string
 	tokenizer := html.NewTokenizer(reader)
+	inHead := true
+	gotBodyLD := false
 
 	for {
 		tokenType := tokenizer.Next()
 
 		if tokenType == html.ErrorToken {
 			err := tokenizer.Err()
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				warnings = append(warnings, fmt.Sprintf("error tokenizing HTML: %v", err))
 			}
-
-			log.Fatalf("error tokenizing HTML: %v", tokenizer.Err())
-			return title, nil, tokenizer.Err()
+			break
 		}
 
 		t := tokenizer.Token()
@@ -70,7 +237,7 @@ func getTags(reader io.Reader) (string, map[string]string, error) {
 
 		// </head>
 		if tokenType == html.EndTagToken && t.DataAtom.String() == "head" {
-			break
+			inHead = false
 		}
 
 		// <title></title>
@@ -98,8 +265,250 @@ func getTags(reader io.Reader) (string, map[string]string, error) {
 			}
 			metas[key] = val
 		}
+
+		// <link rel="alternate" type="application/json+oembed" href="...">
+		if name == "link" && inHead {
+			rel, typ, href := "", "", ""
+			for _, v := range attrs {
+				switch v.Key {
+				case "rel":
+					rel = v.Val
+				case "type":
+					typ = v.Val
+				case "href":
+					href = v.Val
+				}
+			}
+			if rel == "alternate" && typ == "application/json+oembed" {
+				oembedHref = href
+			}
+		}
+
+		// <script type="application/ld+json">...</script>
+		if tokenType == html.StartTagToken && name == "script" {
+			isLD := false
+			for _, v := range attrs {
+				if v.Key == "type" && v.Val == "application/ld+json" {
+					isLD = true
+				}
+			}
+			if isLD && (inHead || !gotBodyLD) {
+				tokenizer.Next()
+				t = tokenizer.Token()
+				blocks, err := parseJSONLD(t.Data)
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("json-ld parse error: %v", err))
+				} else if blocks != nil {
+					jsonld = append(jsonld, blocks...)
+				}
+				if !inHead {
+					gotBodyLD = true
+				}
+			}
+		}
+
+		if !inHead && gotBodyLD {
+			break
+		}
 	}
-	return title, metas, nil
+	return title, metas, jsonld, oembedHref, warnings
+}
+
+// fetchOEmbed resolves href against base (the page that advertised it) and
+// fetches the referenced oEmbed JSON document through the same
+// charset-aware decoding path used for the page itself.
+func fetchOEmbed(base *url.URL, href string) (map[string]interface{}, error) {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	target := base.ResolveReference(ref)
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("oembed endpoint returned %s", res.Status)
+	}
+
+	body := io.LimitReader(res.Body, maxBodyByte)
+	var oembed map[string]interface{}
+	dec := json.NewDecoder(convertUTF8(body, res.Header.Get("Content-Type")))
+	if err := dec.Decode(&oembed); err != nil {
+		return nil, err
+	}
+	return oembed, nil
+}
+
+// AuthHandlerFor picks the auth scheme for a request: explicit ?auth=...
+// query parameters take priority, falling back to any credentials
+// configured for the target hostname.
+func AuthHandlerFor(queries url.Values, host string) fetcher.AuthHandler {
+	switch queries.Get("auth") {
+	case "basic":
+		return fetcher.BasicAuth{User: queries.Get("user"), Pass: queries.Get("pass")}
+	case "bearer":
+		return fetcher.BearerAuth{Token: queries.Get("token")}
+	case "digest":
+		return fetcher.DigestAuth{User: queries.Get("user"), Pass: queries.Get("pass")}
+	}
+
+	if cfg, ok := hostCredentials[host]; ok {
+		return cfg.AuthHandler()
+	}
+	return fetcher.NoAuth{}
+}
+
+// CacheKeyFor derives the cache key for req, folding in the auth identity
+// so a page fetched with one set of credentials (or none) never shares a
+// cache entry with the same URL fetched under different credentials.
+func CacheKeyFor(req *http.Request, auth fetcher.AuthHandler) string {
+	key := req.URL.String()
+	if authKey := fetcher.AuthKey(auth); authKey != "" {
+		key += "#auth=" + authKey
+	}
+	return key
+}
+
+// RendererFor picks the rendering backend for a request: the default
+// StaticRenderer, or the configured ChromeDP backend when ?render=js asks
+// for JS-rendered pages.
+func RendererFor(queries url.Values) renderer.Renderer {
+	if queries.Get("render") != "js" {
+		return renderer.StaticRenderer{}
+	}
+	return cdpRenderer
+}
+
+// FetchAndBuild performs the upstream fetch and tag extraction for req,
+// returning the assembled Response along with the TTL the origin wants it
+// cached for. It holds none of the caching policy itself so it can be
+// reused for both the synchronous miss path and async SWR refreshes.
+func FetchAndBuild(req *http.Request, skipOEmbed bool, auth fetcher.AuthHandler, render renderer.Renderer) (*Response, time.Duration, error) {
+	res, err := auth.Do(httpClient, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	limited := io.LimitReader(res.Body, maxBodyByte)
+	static, err := io.ReadAll(convertUTF8(limited, res.Header.Get("Content-Type")))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var warnings []string
+	rendered, err := render.Render(req.Context(), req.URL.String(), static)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("render fallback to static: %v", err))
+		rendered = static
+	}
+
+	title, metas, jsonld, oembedHref, tagWarnings := getTags(bytes.NewReader(rendered))
+	warnings = append(warnings, tagWarnings...)
+
+	data := &Response{
+		Host:       req.Host,
+		Metas:      metas,
+		JSONLD:     jsonld,
+		Warnings:   warnings,
+		Proto:      res.Proto,
+		StatusCode: res.StatusCode,
+		StatusText: res.Status,
+		Success:    true,
+		Title:      title,
+		URL:        req.URL.String(),
+	}
+
+	if !skipOEmbed && oembedHref != "" {
+		oembed, err := fetchOEmbed(req.URL, oembedHref)
+		if err != nil {
+			data.Warnings = append(data.Warnings, fmt.Sprintf("oembed fetch error: %v", err))
+		} else {
+			data.OEmbed = oembed
+		}
+	}
+
+	return data, cache.ParseTTL(res.Header, cache.DefaultTTL), nil
+}
+
+// StoreInCache saves data under key with the given TTL and the configured
+// stale-while-revalidate window. A non-positive ttl means the origin opted
+// out of caching (Cache-Control: no-store/no-cache, or an Expires already
+// in the past) and must never be served later, stale or not, so the entry
+// is simply not stored.
+func StoreInCache(key string, data *Response, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	size, _ := json.Marshal(data)
+	cache.Default.Set(key, &cache.Entry{
+		Value:    data,
+		Size:     len(size),
+		StoredAt: time.Now(),
+		TTL:      ttl,
+		StaleTTL: cache.StaleWindow,
+	})
+}
+
+// RefreshCache refetches req in the background to replace a stale cache
+// entry, collapsing concurrent refreshes of the same key into one fetch.
+func RefreshCache(req *http.Request, key string, skipOEmbed bool, auth fetcher.AuthHandler, render renderer.Renderer) {
+	refetchGroup.Do(key, func() (interface{}, error) {
+		data, ttl, err := FetchAndBuild(req, skipOEmbed, auth, render)
+		if err != nil {
+			return nil, err
+		}
+		StoreInCache(key, data, ttl)
+		return nil, nil
+	})
+}
+
+// CacheLookup checks the shared cache for key and reports whether a usable
+// entry was found along with its freshness: "HIT" if still within TTL,
+// "STALE" if past TTL but still inside the stale-while-revalidate window.
+// Callers that get back "STALE" should kick off a RefreshCache in the
+// background so the entry doesn't sit there getting staler forever.
+// Shared by Handler and the batch endpoint so both read the cache the same
+// way.
+func CacheLookup(key string) (data *Response, status string, ok bool) {
+	entry, found := cache.Default.Get(key)
+	if !found {
+		return nil, "", false
+	}
+	data, ok = entry.Value.(*Response)
+	if !ok {
+		return nil, "", false
+	}
+	now := time.Now()
+	if !entry.Expired(now) {
+		return data, "HIT", true
+	}
+	if !entry.Stale(now) {
+		return data, "STALE", true
+	}
+	return nil, "", false
+}
+
+// writeResponse marshals data as the JSON body, tagging the result with the
+// X-Cache status (HIT, STALE or MISS) the cache layer decided on.
+func writeResponse(w http.ResponseWriter, data *Response, cacheStatus string) {
+	msg, _ := json.Marshal(data)
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Add("Access-Control-Allow-Methods", "GET,OPTIONS")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Max-Age", "86400")
+	w.Header().Add("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Add("X-Cache", cacheStatus)
+	w.Write(msg)
 }
 
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -129,53 +538,43 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	url := queries.Get("url")
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Fatalf("error request HTML: %v", err)
-		w.WriteHeader(500)
-		w.Write([]byte("500 Internal Server Error\n"))
-		data := ResponseError{Success: false, Msg: err.Error()}
-		msg, _ := json.Marshal(data)
-		fmt.Fprintf(w, string(msg))
+		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// get
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("error get HTML: %v", err)
-		w.WriteHeader(500)
-		w.Write([]byte("500 Internal Server Error\n"))
-		data := ResponseError{Success: false, Msg: err.Error()}
-		msg, _ := json.Marshal(data)
-		fmt.Fprintf(w, string(msg))
-		return
+	skipOEmbed := queries.Get("oembed") == "false"
+	noCache := queries.Get("nocache") == "1"
+	auth := AuthHandlerFor(queries, req.URL.Hostname())
+	render := RendererFor(queries)
+	key := CacheKeyFor(req, auth)
+
+	if !noCache {
+		if data, status, ok := CacheLookup(key); ok {
+			switch status {
+			case "HIT":
+				cache.Default.RecordHit()
+				writeResponse(w, data, "HIT")
+				return
+			case "STALE":
+				cache.Default.RecordStale()
+				writeResponse(w, data, "STALE")
+				go RefreshCache(req, key, skipOEmbed, auth, render)
+				return
+			}
+		}
+		cache.Default.RecordMiss()
 	}
-	defer res.Body.Close()
 
-	data := Response{Success: false}
-	title, metas, err := getTags(convertUTF8(res.Body, res.Header.Get("Content-Type")))
+	// get
+	data, ttl, err := FetchAndBuild(req, skipOEmbed, auth, render)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("500 Internal Server Error\n"))
-		data := ResponseError{Success: false, Msg: err.Error()}
-		msg, _ := json.Marshal(data)
-		fmt.Fprintf(w, string(msg))
+		WriteError(w, http.StatusBadGateway, err.Error())
 		return
 	}
 
-	data.Host = req.Host
-	data.Metas = metas
-	data.Proto = res.Proto
-	data.StatusCode = res.StatusCode
-	data.StatusText = res.Status
-	data.Success = true
-	data.Title = title
-	data.URL = req.URL.String()
+	if !noCache {
+		StoreInCache(key, data, ttl)
+	}
 
-	msg, _ := json.Marshal(data)
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Add("Access-Control-Allow-Methods", "GET,OPTIONS")
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Max-Age", "86400")
-	w.Header().Add("Content-Type", "application/json;charset=UTF-8")
-	fmt.Fprintf(w, string(msg))
+	writeResponse(w, data, "MISS")
 }
@@ -0,0 +1,213 @@
+// Package cache provides an in-memory, size-bounded LRU cache for upstream
+// fetch results, with stale-while-revalidate freshness windows derived from
+// the origin's Cache-Control / Expires headers.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxItems = 1000
+	defaultMaxBytes = 50 * 1024 * 1024 // 50 MB
+	defaultTTL      = 5 * time.Minute
+	defaultStaleTTL = 1 * time.Minute
+)
+
+var (
+	// DefaultTTL and StaleWindow are the fallback freshness windows used
+	// when an origin doesn't send Cache-Control / Expires, tunable via
+	// METAAPI_CACHE_DEFAULT_TTL and METAAPI_CACHE_STALE_TTL (seconds).
+	DefaultTTL  = envDuration("METAAPI_CACHE_DEFAULT_TTL", defaultTTL)
+	StaleWindow = envDuration("METAAPI_CACHE_STALE_TTL", defaultStaleTTL)
+
+	// Default is the process-wide page cache shared by the fetch handler
+	// and the /cache/stats endpoint.
+	Default = NewLRU(
+		envInt("METAAPI_CACHE_MAX_ITEMS", defaultMaxItems),
+		envInt("METAAPI_CACHE_MAX_BYTES", defaultMaxBytes),
+	)
+)
+
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return def
+}
+
+// Entry is a single cached value alongside the freshness window that was in
+// effect when it was stored.
+type Entry struct {
+	Value    interface{}
+	Size     int
+	StoredAt time.Time
+	TTL      time.Duration
+	StaleTTL time.Duration
+}
+
+// Expired reports whether the entry is past its TTL and should no longer be
+// served as a fresh hit.
+func (e *Entry) Expired(now time.Time) bool {
+	return now.Sub(e.StoredAt) > e.TTL
+}
+
+// Stale reports whether the entry is past even its stale-while-revalidate
+// window, meaning it can no longer be served at all.
+func (e *Entry) Stale(now time.Time) bool {
+	return now.Sub(e.StoredAt) > e.TTL+e.StaleTTL
+}
+
+// Stats is a snapshot of cumulative cache counters, as reported by
+// /cache/stats.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Stale  int64 `json:"stale"`
+}
+
+// Cache is the interface the fetch handler depends on, so the storage
+// backend can be swapped out without touching call sites.
+type Cache interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	RecordHit()
+	RecordMiss()
+	RecordStale()
+	Stats() Stats
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// LRU is an in-memory Cache bounded by both entry count and total byte size;
+// whichever limit is hit first evicts the least recently used entry.
+type LRU struct {
+	mu       sync.Mutex
+	ll       *list.List
+	items    map[string]*list.Element
+	maxItems int
+	maxBytes int
+	curBytes int
+
+	hits   int64
+	misses int64
+	stale  int64
+}
+
+// NewLRU creates an LRU bounded by maxItems entries and maxBytes of total
+// entry size. A non-positive limit disables that particular bound.
+func NewLRU(maxItems, maxBytes int) *LRU {
+	return &LRU{
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+	}
+}
+
+func (c *LRU) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+func (c *LRU) Set(key string, entry *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.curBytes += entry.Size - el.Value.(*lruItem).entry.Size
+		el.Value.(*lruItem).entry = entry
+		c.ll.MoveToFront(el)
+	} else {
+		el := c.ll.PushFront(&lruItem{key: key, entry: entry})
+		c.items[key] = el
+		c.curBytes += entry.Size
+	}
+
+	for (c.maxItems > 0 && c.ll.Len() > c.maxItems) || (c.maxBytes > 0 && c.curBytes > c.maxBytes) {
+		c.evictOldest()
+	}
+}
+
+func (c *LRU) evictOldest() {
+	el := c.ll.Back()
+	if el == nil {
+		return
+	}
+	c.ll.Remove(el)
+	item := el.Value.(*lruItem)
+	delete(c.items, item.key)
+	c.curBytes -= item.entry.Size
+}
+
+func (c *LRU) RecordHit()   { atomic.AddInt64(&c.hits, 1) }
+func (c *LRU) RecordMiss()  { atomic.AddInt64(&c.misses, 1) }
+func (c *LRU) RecordStale() { atomic.AddInt64(&c.stale, 1) }
+
+func (c *LRU) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+		Stale:  atomic.LoadInt64(&c.stale),
+	}
+}
+
+// ParseTTL derives a freshness window from the upstream response headers,
+// preferring Cache-Control's max-age, falling back to Expires, and finally
+// to fallback when neither header is present or parseable. A no-store or
+// no-cache directive yields a zero TTL so the entry is never served stale.
+func ParseTTL(h http.Header, fallback time.Duration) time.Duration {
+	if cc := h.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "no-store" || directive == "no-cache" {
+				return 0
+			}
+			if strings.HasPrefix(directive, "max-age=") {
+				secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+				if err == nil {
+					return time.Duration(secs) * time.Second
+				}
+			}
+		}
+	}
+
+	if exp := h.Get("Expires"); exp != "" {
+		if t, err := http.ParseTime(exp); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	cases := []struct {
+		name     string
+		header   http.Header
+		fallback time.Duration
+		want     time.Duration
+	}{
+		{
+			name:     "max-age wins over Expires",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Expires": {time.Now().Add(time.Hour).Format(http.TimeFormat)}},
+			fallback: time.Minute,
+			want:     60 * time.Second,
+		},
+		{
+			name:     "no-store yields zero",
+			header:   http.Header{"Cache-Control": {"no-store"}},
+			fallback: time.Minute,
+			want:     0,
+		},
+		{
+			name:     "no-cache yields zero",
+			header:   http.Header{"Cache-Control": {"no-cache"}},
+			fallback: time.Minute,
+			want:     0,
+		},
+		{
+			name:     "unparseable max-age falls through to fallback",
+			header:   http.Header{"Cache-Control": {"max-age=banana"}},
+			fallback: 42 * time.Second,
+			want:     42 * time.Second,
+		},
+		{
+			name:     "Expires in the past yields zero",
+			header:   http.Header{"Expires": {time.Now().Add(-time.Hour).Format(http.TimeFormat)}},
+			fallback: time.Minute,
+			want:     0,
+		},
+		{
+			name:     "unparseable Expires falls through to fallback",
+			header:   http.Header{"Expires": {"not a date"}},
+			fallback: 30 * time.Second,
+			want:     30 * time.Second,
+		},
+		{
+			name:     "no headers uses fallback",
+			header:   http.Header{},
+			fallback: 5 * time.Minute,
+			want:     5 * time.Minute,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ParseTTL(c.header, c.fallback)
+			if got != c.want {
+				t.Errorf("ParseTTL() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLRUSetEvictsByItemCount(t *testing.T) {
+	c := NewLRU(2, 0)
+	c.Set("a", &Entry{Size: 1})
+	c.Set("b", &Entry{Size: 1})
+	c.Set("c", &Entry{Size: 1})
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("oldest entry \"a\" should have been evicted once the item limit was exceeded")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("\"b\" should still be cached")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("\"c\" should still be cached")
+	}
+}
+
+func TestLRUSetEvictsByByteSize(t *testing.T) {
+	c := NewLRU(0, 10)
+	c.Set("a", &Entry{Size: 6})
+	c.Set("b", &Entry{Size: 6})
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("\"a\" should have been evicted once the byte limit was exceeded")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("\"b\" should still be cached")
+	}
+}
+
+func TestLRUSetTouchesRecency(t *testing.T) {
+	c := NewLRU(2, 0)
+	c.Set("a", &Entry{Size: 1})
+	c.Set("b", &Entry{Size: 1})
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	c.Get("a")
+	c.Set("c", &Entry{Size: 1})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("\"b\" should have been evicted as the least recently used entry")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("\"a\" should still be cached after being touched")
+	}
+}
+
+func TestLRUSetOverwritesExistingKey(t *testing.T) {
+	c := NewLRU(0, 10)
+	c.Set("a", &Entry{Size: 8, Value: "first"})
+	c.Set("a", &Entry{Size: 8, Value: "second"})
+
+	entry, ok := c.Get("a")
+	if !ok {
+		t.Fatal("\"a\" should still be cached")
+	}
+	if entry.Value != "second" {
+		t.Errorf("Get(\"a\").Value = %v, want %q", entry.Value, "second")
+	}
+	if c.curBytes != 8 {
+		t.Errorf("curBytes = %d, want 8 (overwrite should replace, not add, byte accounting)", c.curBytes)
+	}
+}
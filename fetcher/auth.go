@@ -0,0 +1,251 @@
+// Package fetcher factors the authenticated upstream HTTP call out of the
+// handler so protected pages (intranet sites, staging behind a login) can
+// be fetched the same way public ones are.
+package fetcher
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// AuthHandler performs an HTTP request using whatever credentials it holds,
+// handling any auth challenge round trip the scheme requires (digest's 401
+// dance) before returning the final response.
+type AuthHandler interface {
+	Do(client *http.Client, req *http.Request) (*http.Response, error)
+}
+
+// NoAuth performs the request unmodified.
+type NoAuth struct{}
+
+func (NoAuth) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	return client.Do(req)
+}
+
+// BasicAuth sets HTTP Basic credentials before the request is sent.
+type BasicAuth struct {
+	User string
+	Pass string
+}
+
+func (a BasicAuth) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	req.SetBasicAuth(a.User, a.Pass)
+	return client.Do(req)
+}
+
+// BearerAuth sets an Authorization: Bearer header before the request is
+// sent.
+type BearerAuth struct {
+	Token string
+}
+
+func (a BearerAuth) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return client.Do(req)
+}
+
+// DigestAuth implements the RFC 2617 challenge/response dance: an initial
+// request, a 401 carrying a WWW-Authenticate challenge, then a retry with a
+// computed Authorization: Digest header.
+type DigestAuth struct {
+	User string
+	Pass string
+}
+
+func (a DigestAuth) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+
+	c, err := parseDigestChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := a.authorizationHeader(c, req)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", header)
+	return client.Do(retry)
+}
+
+func (a DigestAuth) authorizationHeader(c *digestChallenge, req *http.Request) (string, error) {
+	if c.algorithm != "" && !strings.EqualFold(c.algorithm, "MD5") {
+		return "", fmt.Errorf("fetcher: unsupported digest algorithm %q", c.algorithm)
+	}
+
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", a.User, c.realm, a.Pass))
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", req.Method, uri))
+
+	cnonce, err := randomCnonce()
+	if err != nil {
+		return "", err
+	}
+	const nc = "00000001"
+
+	qop := firstQop(c.qop)
+	var response string
+	if qop != "" {
+		response = md5Hex(strings.Join([]string{ha1, c.nonce, nc, cnonce, qop, ha2}, ":"))
+	} else {
+		response = md5Hex(strings.Join([]string{ha1, c.nonce, ha2}, ":"))
+	}
+
+	header := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		a.User, c.realm, c.nonce, uri, response,
+	)
+	if qop != "" {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if c.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, c.opaque)
+	}
+	return header, nil
+}
+
+// digestChallenge is the parsed form of a WWW-Authenticate: Digest header.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	qop       string
+	opaque    string
+	algorithm string
+}
+
+var digestParamRE = regexp.MustCompile(`(\w+)=("[^"]*"|[^,]*)`)
+
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	const prefix = "Digest "
+	if !strings.HasPrefix(header, prefix) {
+		return nil, fmt.Errorf("fetcher: not a Digest challenge: %q", header)
+	}
+
+	c := &digestChallenge{algorithm: "MD5"}
+	for _, part := range digestParamRE.FindAllString(strings.TrimPrefix(header, prefix), -1) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		val := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		switch key {
+		case "realm":
+			c.realm = val
+		case "nonce":
+			c.nonce = val
+		case "qop":
+			c.qop = val
+		case "opaque":
+			c.opaque = val
+		case "algorithm":
+			c.algorithm = val
+		}
+	}
+	if c.nonce == "" {
+		return nil, fmt.Errorf("fetcher: Digest challenge missing nonce")
+	}
+	return c, nil
+}
+
+func firstQop(qop string) string {
+	if qop == "" {
+		return ""
+	}
+	return strings.TrimSpace(strings.Split(qop, ",")[0])
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomCnonce() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// AuthKey returns a cache-key fragment that uniquely identifies the
+// credentials an AuthHandler carries, so a response fetched with one set of
+// credentials (or none) is never served back for a request using another.
+// It hashes the actual secret material rather than embedding it verbatim,
+// since the result ends up in the shared, process-wide cache key.
+func AuthKey(auth AuthHandler) string {
+	switch a := auth.(type) {
+	case NoAuth:
+		return ""
+	case BasicAuth:
+		return "basic:" + hashSecret(a.User, a.Pass)
+	case BearerAuth:
+		return "bearer:" + hashSecret(a.Token)
+	case DigestAuth:
+		return "digest:" + hashSecret(a.User, a.Pass)
+	default:
+		return "auth"
+	}
+}
+
+func hashSecret(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// CredentialConfig is a single hostname's configured credentials, as loaded
+// from METAAPI_AUTH_CONFIG.
+type CredentialConfig struct {
+	Type  string `json:"type"` // "basic", "bearer", or "digest"
+	User  string `json:"user,omitempty"`
+	Pass  string `json:"pass,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// AuthHandler builds the AuthHandler this credential config describes.
+func (c CredentialConfig) AuthHandler() AuthHandler {
+	switch c.Type {
+	case "basic":
+		return BasicAuth{User: c.User, Pass: c.Pass}
+	case "bearer":
+		return BearerAuth{Token: c.Token}
+	case "digest":
+		return DigestAuth{User: c.User, Pass: c.Pass}
+	default:
+		return NoAuth{}
+	}
+}
+
+// LoadHostCredentials parses METAAPI_AUTH_CONFIG, a JSON object mapping
+// hostname to CredentialConfig, used for pages whose request doesn't carry
+// explicit auth query parameters.
+func LoadHostCredentials() map[string]CredentialConfig {
+	raw := os.Getenv("METAAPI_AUTH_CONFIG")
+	if raw == "" {
+		return nil
+	}
+	var cfg map[string]CredentialConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil
+	}
+	return cfg
+}
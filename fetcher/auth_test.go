@@ -0,0 +1,135 @@
+package fetcher
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseDigestChallenge(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		want    *digestChallenge
+		wantErr bool
+	}{
+		{
+			name:   "full challenge",
+			header: `Digest realm="example.com", qop="auth", nonce="abc123", opaque="xyz", algorithm=MD5`,
+			want:   &digestChallenge{realm: "example.com", nonce: "abc123", qop: "auth", opaque: "xyz", algorithm: "MD5"},
+		},
+		{
+			name:   "missing algorithm defaults to MD5",
+			header: `Digest realm="example.com", nonce="abc123"`,
+			want:   &digestChallenge{realm: "example.com", nonce: "abc123", algorithm: "MD5"},
+		},
+		{
+			name:    "missing nonce is an error",
+			header:  `Digest realm="example.com"`,
+			wantErr: true,
+		},
+		{
+			name:    "not a digest challenge",
+			header:  `Basic realm="example.com"`,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseDigestChallenge(c.header)
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != *c.want {
+				t.Errorf("parseDigestChallenge() = %+v, want %+v", *got, *c.want)
+			}
+		})
+	}
+}
+
+func TestDigestAuthAuthorizationHeader(t *testing.T) {
+	auth := DigestAuth{User: "admin", Pass: "secret"}
+	challenge := &digestChallenge{realm: "example.com", nonce: "abcnonce", qop: "auth", algorithm: "MD5"}
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/private", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := auth.authorizationHeader(challenge, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cnonce := mustExtract(t, header, `cnonce="([^"]*)"`)
+	response := mustExtract(t, header, `response="([^"]*)"`)
+
+	ha1 := md5Hex("admin:example.com:secret")
+	ha2 := md5Hex("GET:/private")
+	want := md5Hex(strings.Join([]string{ha1, challenge.nonce, "00000001", cnonce, "auth", ha2}, ":"))
+
+	if response != want {
+		t.Errorf("authorizationHeader response = %s, want %s", response, want)
+	}
+	if !strings.Contains(header, `username="admin"`) {
+		t.Errorf("header missing username: %s", header)
+	}
+	if !strings.Contains(header, "nc=00000001") {
+		t.Errorf("header missing nc: %s", header)
+	}
+}
+
+func TestDigestAuthAuthorizationHeaderWithoutQop(t *testing.T) {
+	auth := DigestAuth{User: "admin", Pass: "secret"}
+	challenge := &digestChallenge{realm: "example.com", nonce: "abcnonce", algorithm: "MD5"}
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/private", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := auth.authorizationHeader(challenge, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	response := mustExtract(t, header, `response="([^"]*)"`)
+	ha1 := md5Hex("admin:example.com:secret")
+	ha2 := md5Hex("GET:/private")
+	want := md5Hex(strings.Join([]string{ha1, challenge.nonce, ha2}, ":"))
+
+	if response != want {
+		t.Errorf("authorizationHeader response = %s, want %s", response, want)
+	}
+	if strings.Contains(header, "qop=") {
+		t.Errorf("header should omit qop when the challenge didn't request one: %s", header)
+	}
+}
+
+func TestDigestAuthAuthorizationHeaderRejectsUnsupportedAlgorithm(t *testing.T) {
+	auth := DigestAuth{User: "admin", Pass: "secret"}
+	challenge := &digestChallenge{realm: "example.com", nonce: "abcnonce", algorithm: "SHA-256"}
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/private", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth.authorizationHeader(challenge, req); err == nil {
+		t.Error("expected an error for an unsupported digest algorithm")
+	}
+}
+
+func mustExtract(t *testing.T, s, pattern string) string {
+	t.Helper()
+	m := regexp.MustCompile(pattern).FindStringSubmatch(s)
+	if len(m) != 2 {
+		t.Fatalf("pattern %q not found in %q", pattern, s)
+	}
+	return m[1]
+}
@@ -0,0 +1,150 @@
+// Package renderer provides pluggable page-rendering backends. Most pages
+// can be scraped from their raw HTTP response, but a growing share only
+// populate <title> and meta tags after client-side JavaScript runs; for
+// those, ChromeDPRenderer drives a real browser instead.
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	defaultTimeout = 8 * time.Second
+
+	// networkIdleSettle and networkIdlePoll tune waitNetworkIdle: the page
+	// must have no in-flight requests for networkIdleSettle, checked every
+	// networkIdlePoll, before it's considered done loading.
+	networkIdleSettle = 500 * time.Millisecond
+	networkIdlePoll   = 50 * time.Millisecond
+)
+
+// Renderer produces the HTML that tag extraction should run against for a
+// page, given the HTML already fetched over plain HTTP.
+type Renderer interface {
+	Render(ctx context.Context, rawURL string, staticHTML []byte) ([]byte, error)
+}
+
+// StaticRenderer is the default backend: it returns the already-fetched
+// HTML unchanged.
+type StaticRenderer struct{}
+
+func (StaticRenderer) Render(ctx context.Context, rawURL string, staticHTML []byte) ([]byte, error) {
+	return staticHTML, nil
+}
+
+// ChromeDPRenderer loads rawURL in a headless Chrome instance reached over
+// the Chrome DevTools Protocol and returns the rendered DOM. AllowedHosts
+// restricts which hostnames may be rendered this way so the endpoint
+// doesn't become an open headless-browsing proxy.
+type ChromeDPRenderer struct {
+	CDPURL       string
+	AllowedHosts map[string]bool
+	Timeout      time.Duration
+}
+
+func (c ChromeDPRenderer) Render(ctx context.Context, rawURL string, staticHTML []byte) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !c.AllowedHosts[u.Hostname()] {
+		return nil, fmt.Errorf("renderer: host %q is not allowlisted for JS rendering", u.Hostname())
+	}
+	if c.CDPURL == "" {
+		return nil, fmt.Errorf("renderer: METAAPI_CDP_URL is not configured")
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	allocCtx, cancelAlloc := chromedp.NewRemoteAllocator(ctx, c.CDPURL)
+	defer cancelAlloc()
+
+	taskCtx, cancelTask := chromedp.NewContext(allocCtx)
+	defer cancelTask()
+
+	taskCtx, cancelTimeout := context.WithTimeout(taskCtx, timeout)
+	defer cancelTimeout()
+
+	var renderedHTML string
+	err = chromedp.Run(taskCtx,
+		chromedp.Navigate(rawURL),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		waitNetworkIdle(networkIdleSettle),
+		chromedp.OuterHTML("html", &renderedHTML, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("renderer: chromedp render failed: %w", err)
+	}
+	return []byte(renderedHTML), nil
+}
+
+// waitNetworkIdle returns a chromedp action that blocks until the page has
+// had no in-flight network request for settle, so XHR/fetch calls kicked
+// off by the page's own JS have a chance to finish before the DOM is read.
+// It relies on the caller's context deadline (taskCtx above) to bail out of
+// pages that never go idle.
+func waitNetworkIdle(settle time.Duration) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var inflight int64
+		var lastActivity atomic.Value
+		lastActivity.Store(time.Now())
+
+		chromedp.ListenTarget(ctx, func(ev interface{}) {
+			switch ev.(type) {
+			case *network.EventRequestWillBeSent:
+				atomic.AddInt64(&inflight, 1)
+				lastActivity.Store(time.Now())
+			case *network.EventLoadingFinished, *network.EventLoadingFailed:
+				atomic.AddInt64(&inflight, -1)
+				lastActivity.Store(time.Now())
+			}
+		})
+
+		if err := network.Enable().Do(ctx); err != nil {
+			return err
+		}
+
+		ticker := time.NewTicker(networkIdlePoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				quiet := time.Since(lastActivity.Load().(time.Time)) >= settle
+				if atomic.LoadInt64(&inflight) <= 0 && quiet {
+					return nil
+				}
+			}
+		}
+	})
+}
+
+// AllowedHostsFromEnv parses METAAPI_CDP_ALLOWED_HOSTS, a comma-separated
+// list of hostnames permitted for JS rendering.
+func AllowedHostsFromEnv() map[string]bool {
+	raw := os.Getenv("METAAPI_CDP_ALLOWED_HOSTS")
+	if raw == "" {
+		return nil
+	}
+
+	hosts := make(map[string]bool)
+	for _, h := range strings.Split(raw, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts[h] = true
+		}
+	}
+	return hosts
+}